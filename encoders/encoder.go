@@ -0,0 +1,41 @@
+// Package encoders turns resolved providers.Stream values into the file
+// formats various players expect, so the tool isn't tied to producing
+// AVFoundation plists.
+package encoders
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Tachines/HLS-Example/providers"
+)
+
+// Encoder writes a batch of resolved streams to disk.
+type Encoder interface {
+	// Name identifies the encoder for --format.
+	Name() string
+	// MultiFile reports whether Encode writes one file per stream under an
+	// output directory (true), or a single document to a writer (false).
+	MultiFile() bool
+	// Encode writes streams to out for single-file formats, or as
+	// individual files under outputDir for multi-file ones. The unused
+	// parameter is ignored by each implementation.
+	Encode(streams []*providers.Stream, out io.Writer, outputDir string) error
+}
+
+var registry = map[string]Encoder{}
+
+// Register makes an Encoder available by name. Encoders call this from an
+// init() function.
+func Register(e Encoder) {
+	registry[e.Name()] = e
+}
+
+// Get looks up a registered encoder by name.
+func Get(name string) (Encoder, error) {
+	e, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q", name)
+	}
+	return e, nil
+}
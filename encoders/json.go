@@ -0,0 +1,26 @@
+package encoders
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/Tachines/HLS-Example/providers"
+)
+
+func init() {
+	Register(jsonEncoder{})
+}
+
+// jsonEncoder writes the resolved streams as a single JSON array,
+// preserving every field a provider filled in (DRM, subtitles, etc.) for
+// consumers that don't care about AVFoundation's plist shape.
+type jsonEncoder struct{}
+
+func (jsonEncoder) Name() string    { return "json" }
+func (jsonEncoder) MultiFile() bool { return false }
+
+func (jsonEncoder) Encode(streams []*providers.Stream, out io.Writer, _ string) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(streams)
+}
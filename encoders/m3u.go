@@ -0,0 +1,45 @@
+package encoders
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Tachines/HLS-Example/providers"
+)
+
+func init() {
+	Register(m3uEncoder{})
+}
+
+// m3uEncoder writes an extended M3U playlist with a #KODIPROP line per
+// entry carrying the DRM content ID, so Kodi's inputstream.adaptive can
+// play the stream straight out of the playlist.
+type m3uEncoder struct{}
+
+func (m3uEncoder) Name() string    { return "m3u" }
+func (m3uEncoder) MultiFile() bool { return false }
+
+func (m3uEncoder) Encode(streams []*providers.Stream, out io.Writer, _ string) error {
+	if _, err := fmt.Fprintln(out, "#EXTM3U"); err != nil {
+		return err
+	}
+	for _, s := range streams {
+		if _, err := fmt.Fprintf(out, "#EXTINF:-1,%s\n", s.Title); err != nil {
+			return err
+		}
+		if s.DRM.ContentID != "" {
+			if _, err := fmt.Fprintf(out, "#KODIPROP:inputstream.adaptive.license_key=%s\n", s.DRM.ContentID); err != nil {
+				return err
+			}
+		}
+		for _, sub := range s.Subtitles {
+			if _, err := fmt.Fprintf(out, "#EXTVLCOPT:sub-file=%s\n", sub.URL); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(out, s.HLSURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package encoders
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Tachines/HLS-Example/providers"
+)
+
+func init() {
+	Register(plistEncoder{})
+}
+
+// plistEncoder writes the Apple AVFoundation FairPlay download plist: an
+// <array> of <dict> elements, one per resolved title.
+type plistEncoder struct{}
+
+func (plistEncoder) Name() string    { return "plist" }
+func (plistEncoder) MultiFile() bool { return false }
+
+func (plistEncoder) Encode(streams []*providers.Stream, out io.Writer, _ string) error {
+	if _, err := fmt.Fprintln(out, "<array>"); err != nil {
+		return err
+	}
+	for _, s := range streams {
+		if err := encodePlistDict(out, s); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(out, "</array>")
+	return err
+}
+
+func encodePlistDict(out io.Writer, s *providers.Stream) error {
+	dict := `
+    <dict>
+        <key>AssetNameKey</key>
+        <string>%s</string>
+        <key>AAPLStreamPlaylistURL</key>
+        <string>%s</string>
+        <key>ContentID</key>
+        <string>%s</string>
+        <key>ProgramID</key>
+        <string>%s</string>
+    </dict>`
+	_, err := fmt.Fprintf(out, dict+"\n", s.Title, s.HLSURL, strings.Replace(s.DRM.ContentID, "&", "&amp;", -1), s.ProgramID)
+	return err
+}
@@ -0,0 +1,42 @@
+package encoders
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/Tachines/HLS-Example/providers"
+)
+
+func init() {
+	Register(strmEncoder{})
+}
+
+// strmEncoder writes one Kodi .strm file per stream, each containing the
+// HLS URL Kodi should play. Unlike the other formats this needs an
+// --output-dir since it produces a file per title rather than one document.
+type strmEncoder struct{}
+
+func (strmEncoder) Name() string    { return "strm" }
+func (strmEncoder) MultiFile() bool { return true }
+
+var strmUnsafeChars = regexp.MustCompile(`[/\\:*?"<>|]`)
+
+func (strmEncoder) Encode(streams []*providers.Stream, _ io.Writer, outputDir string) error {
+	if outputDir == "" {
+		return fmt.Errorf("strm: --output-dir is required")
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+	for _, s := range streams {
+		name := strmUnsafeChars.ReplaceAllString(s.Title, "_") + ".strm"
+		path := filepath.Join(outputDir, name)
+		if err := os.WriteFile(path, []byte(s.HLSURL+"\n"), 0644); err != nil {
+			return fmt.Errorf("writing %s: %s", path, err)
+		}
+	}
+	return nil
+}
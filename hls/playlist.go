@@ -0,0 +1,200 @@
+// Package hls parses HLS master and media playlists into typed structs,
+// so callers can pick a variant, audio track, subtitle track or DRM key by
+// criteria instead of hand-scanning for the first line that looks right.
+package hls
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// VariantStream is one #EXT-X-STREAM-INF entry of a master playlist.
+type VariantStream struct {
+	URI        string
+	Bandwidth  int
+	Resolution string // e.g. "1920x1080"
+	Codecs     string
+	Audio      string // GROUP-ID of the associated #EXT-X-MEDIA AUDIO group
+	Subtitles  string // GROUP-ID of the associated #EXT-X-MEDIA SUBTITLES group
+}
+
+// Media is one #EXT-X-MEDIA entry: an alternate audio, subtitle, closed
+// caption or video rendition.
+type Media struct {
+	Type       string // AUDIO, SUBTITLES, CLOSED-CAPTIONS or VIDEO
+	GroupID    string
+	Name       string
+	Language   string
+	URI        string
+	Default    bool
+	Autoselect bool
+}
+
+// Key is one #EXT-X-KEY entry of a media playlist.
+type Key struct {
+	Method            string
+	URI               string
+	KeyFormat         string
+	KeyFormatVersions string
+	IV                string
+}
+
+// MasterPlaylist is a parsed #EXT-X-STREAM-INF master playlist.
+type MasterPlaylist struct {
+	Variants []VariantStream
+	Media    []Media
+}
+
+// MediaPlaylist is a parsed rendition (segment-level) playlist.
+type MediaPlaylist struct {
+	Keys     []Key
+	Segments []string
+}
+
+// ParseMaster parses a master playlist read from r. base is the URL it was
+// fetched from, used to resolve the relative URIs that appear in it.
+func ParseMaster(base *url.URL, r io.Reader) (*MasterPlaylist, error) {
+	mp := &MasterPlaylist{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			attrs := parseAttributeList(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+			variant := VariantStream{
+				Resolution: attrs["RESOLUTION"],
+				Codecs:     attrs["CODECS"],
+				Audio:      attrs["AUDIO"],
+				Subtitles:  attrs["SUBTITLES"],
+			}
+			if bw, err := strconv.Atoi(attrs["BANDWIDTH"]); err == nil {
+				variant.Bandwidth = bw
+			}
+			if !scanner.Scan() {
+				return nil, fmt.Errorf("hls: %s not followed by a variant URI", line)
+			}
+			resolved, err := resolve(base, strings.TrimSpace(scanner.Text()))
+			if err != nil {
+				return nil, err
+			}
+			variant.URI = resolved
+			mp.Variants = append(mp.Variants, variant)
+
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
+			attrs := parseAttributeList(strings.TrimPrefix(line, "#EXT-X-MEDIA:"))
+			media := Media{
+				Type:       attrs["TYPE"],
+				GroupID:    attrs["GROUP-ID"],
+				Name:       attrs["NAME"],
+				Language:   attrs["LANGUAGE"],
+				Default:    attrs["DEFAULT"] == "YES",
+				Autoselect: attrs["AUTOSELECT"] == "YES",
+			}
+			if attrs["URI"] != "" {
+				resolved, err := resolve(base, attrs["URI"])
+				if err != nil {
+					return nil, err
+				}
+				media.URI = resolved
+			}
+			mp.Media = append(mp.Media, media)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mp, nil
+}
+
+// ParseMedia parses a rendition (segment-level) playlist read from r. base
+// is the URL it was fetched from, used to resolve relative URIs.
+func ParseMedia(base *url.URL, r io.Reader) (*MediaPlaylist, error) {
+	mp := &MediaPlaylist{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			attrs := parseAttributeList(strings.TrimPrefix(line, "#EXT-X-KEY:"))
+			key := Key{
+				Method:            attrs["METHOD"],
+				URI:               attrs["URI"],
+				KeyFormat:         attrs["KEYFORMAT"],
+				KeyFormatVersions: attrs["KEYFORMATVERSIONS"],
+				IV:                attrs["IV"],
+			}
+			if key.URI != "" {
+				resolved, err := resolve(base, key.URI)
+				if err != nil {
+					return nil, err
+				}
+				key.URI = resolved
+			}
+			mp.Keys = append(mp.Keys, key)
+
+		case strings.HasPrefix(line, "#"), line == "":
+			// comment, tag we don't care about, or blank line
+
+		default:
+			resolved, err := resolve(base, strings.TrimSpace(line))
+			if err != nil {
+				return nil, err
+			}
+			mp.Segments = append(mp.Segments, resolved)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mp, nil
+}
+
+func resolve(base *url.URL, ref string) (string, error) {
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("couldn't parse %s as URL: %s", ref, err)
+	}
+	if base == nil {
+		return refURL.String(), nil
+	}
+	return base.ResolveReference(refURL).String(), nil
+}
+
+// parseAttributeList splits an HLS attribute-list (the part of a tag after
+// the colon) into a map, honoring commas inside quoted-string values.
+func parseAttributeList(s string) map[string]string {
+	attrs := map[string]string{}
+	var key, val strings.Builder
+	inQuotes := false
+	inValue := false
+	flush := func() {
+		if key.Len() > 0 {
+			attrs[strings.TrimSpace(key.String())] = strings.Trim(val.String(), `"`)
+		}
+		key.Reset()
+		val.Reset()
+		inValue = false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+			val.WriteByte(c)
+		case c == '=' && !inValue && !inQuotes:
+			inValue = true
+		case c == ',' && !inQuotes:
+			flush()
+		case inValue:
+			val.WriteByte(c)
+		default:
+			key.WriteByte(c)
+		}
+	}
+	flush()
+	return attrs
+}
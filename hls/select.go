@@ -0,0 +1,88 @@
+package hls
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Criteria narrows down which variant stream, audio track and subtitle
+// track to use out of a master playlist. A zero value imposes no filter
+// on that dimension.
+type Criteria struct {
+	MaxBandwidth int    // reject variants above this bitrate, in bits/sec
+	Resolution   string // exact match against VariantStream.Resolution, e.g. "1920x1080"
+	Codec        string // substring match against VariantStream.Codecs
+	AudioLang    string // exact match against Media.Language for AUDIO tracks
+	SubtitleLang string // exact match against Media.Language for SUBTITLES tracks
+}
+
+// SelectVariant returns the highest-bandwidth variant stream matching c.
+// If c.AudioLang or c.SubtitleLang is set, only variants whose AUDIO or
+// SUBTITLES group has a track in that language are considered.
+func (mp *MasterPlaylist) SelectVariant(c Criteria) (*VariantStream, error) {
+	var best *VariantStream
+	for i := range mp.Variants {
+		v := &mp.Variants[i]
+		if c.MaxBandwidth > 0 && v.Bandwidth > c.MaxBandwidth {
+			continue
+		}
+		if c.Resolution != "" && v.Resolution != c.Resolution {
+			continue
+		}
+		if c.Codec != "" && !strings.Contains(v.Codecs, c.Codec) {
+			continue
+		}
+		if c.AudioLang != "" {
+			if v.Audio == "" {
+				continue
+			}
+			if _, err := mp.SelectAudio(v.Audio, c.AudioLang); err != nil {
+				continue
+			}
+		}
+		if c.SubtitleLang != "" {
+			if v.Subtitles == "" {
+				continue
+			}
+			if _, err := mp.SelectSubtitle(v.Subtitles, c.SubtitleLang); err != nil {
+				continue
+			}
+		}
+		if best == nil || v.Bandwidth > best.Bandwidth {
+			best = v
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("hls: no variant stream matches %+v", c)
+	}
+	return best, nil
+}
+
+// SelectAudio returns the first AUDIO media in groupID matching lang, or
+// any language if lang is empty.
+func (mp *MasterPlaylist) SelectAudio(groupID, lang string) (*Media, error) {
+	return mp.selectMedia("AUDIO", groupID, lang)
+}
+
+// SelectSubtitle returns the first SUBTITLES media in groupID matching
+// lang, or any language if lang is empty.
+func (mp *MasterPlaylist) SelectSubtitle(groupID, lang string) (*Media, error) {
+	return mp.selectMedia("SUBTITLES", groupID, lang)
+}
+
+func (mp *MasterPlaylist) selectMedia(mediaType, groupID, lang string) (*Media, error) {
+	for i := range mp.Media {
+		m := &mp.Media[i]
+		if m.Type != mediaType {
+			continue
+		}
+		if groupID != "" && m.GroupID != groupID {
+			continue
+		}
+		if lang != "" && m.Language != lang {
+			continue
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("hls: no %s track matches group=%q lang=%q", mediaType, groupID, lang)
+}
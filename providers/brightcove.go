@@ -0,0 +1,101 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+func init() {
+	Register(&brightcoveProvider{})
+}
+
+// brightcoveProvider talks to Brightcove's Playback API directly, for
+// services that don't front their Brightcove account with an MPX feed.
+// BRIGHTCOVE_ACCOUNT_ID and BRIGHTCOVE_POLICY_KEY must be set; id is the
+// Brightcove video ID (or reference:<ref-id>).
+type brightcoveProvider struct{}
+
+func (p *brightcoveProvider) Name() string { return "brightcove" }
+
+func (p *brightcoveProvider) accountAndKey() (string, string, error) {
+	account := os.Getenv("BRIGHTCOVE_ACCOUNT_ID")
+	policyKey := os.Getenv("BRIGHTCOVE_POLICY_KEY")
+	if account == "" || policyKey == "" {
+		return "", "", fmt.Errorf("brightcove: BRIGHTCOVE_ACCOUNT_ID and BRIGHTCOVE_POLICY_KEY must be set")
+	}
+	return account, policyKey, nil
+}
+
+func (p *brightcoveProvider) get(path, policyKey string, out interface{}) error {
+	req, err := http.NewRequest("GET", "https://edge.api.brightcove.com/playback/v1"+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json;pk="+policyKey)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("brightcove: %s returned %s", path, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func (p *brightcoveProvider) Search(query string) ([]Result, error) {
+	account, policyKey, err := p.accountAndKey()
+	if err != nil {
+		return nil, err
+	}
+	var videos []struct {
+		ID   string
+		Name string
+	}
+	if err := p.get(fmt.Sprintf("/accounts/%s/videos?q=%s", account, url.QueryEscape(query)), policyKey, &videos); err != nil {
+		return nil, err
+	}
+	results := make([]Result, len(videos))
+	for i, v := range videos {
+		results[i] = Result{ID: v.ID, Title: v.Name}
+	}
+	return results, nil
+}
+
+func (p *brightcoveProvider) Resolve(id string, season, episode int) (*Stream, error) {
+	account, policyKey, err := p.accountAndKey()
+	if err != nil {
+		return nil, err
+	}
+	var video struct {
+		Name    string
+		Sources []struct {
+			Src  string `json:"src"`
+			Type string `json:"type"`
+		}
+		TextTracks []struct {
+			Src     string `json:"src"`
+			Srclang string `json:"srclang"`
+		} `json:"text_tracks"`
+	}
+	if err := p.get(fmt.Sprintf("/accounts/%s/videos/%s", account, id), policyKey, &video); err != nil {
+		return nil, err
+	}
+	stream := &Stream{Title: video.Name, ProgramID: id}
+	for _, src := range video.Sources {
+		if src.Type == "application/x-mpegURL" {
+			stream.HLSURL = src.Src
+			break
+		}
+	}
+	if stream.HLSURL == "" {
+		return nil, fmt.Errorf("brightcove: no HLS source found for video %s", id)
+	}
+	for _, track := range video.TextTracks {
+		stream.Subtitles = append(stream.Subtitles, Subtitle{Lang: track.Srclang, URL: track.Src})
+	}
+	return stream, nil
+}
@@ -0,0 +1,97 @@
+package providers
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	mpx "github.com/streamco/streamco-mpx-go"
+)
+
+func init() {
+	Register(&mpxProvider{})
+}
+
+// mpxProvider resolves streams from any Theplatform/MPX media feed whose
+// account is supplied via environment variables, for services that expose
+// a plain MPX feed without a bespoke search API like Stan's.
+//
+// MPX_ACCOUNT_ID must be set. When MPX_TOKEN is also set, both are passed
+// to the MPX client as the account and auth token; without MPX_TOKEN the
+// library's default client is used and MPX_ACCOUNT_ID is not passed on.
+type mpxProvider struct{}
+
+func (p *mpxProvider) Name() string { return "mpx" }
+
+func (p *mpxProvider) client() (mpx.Client, error) {
+	account := os.Getenv("MPX_ACCOUNT_ID")
+	if account == "" {
+		return nil, fmt.Errorf("mpx: MPX_ACCOUNT_ID not set")
+	}
+	if token := os.Getenv("MPX_TOKEN"); token != "" {
+		return mpx.NewClient(account, token), nil
+	}
+	return mpx.NewDefaultClient(), nil
+}
+
+func (p *mpxProvider) Search(query string) ([]Result, error) {
+	client, err := p.client()
+	if err != nil {
+		return nil, err
+	}
+	var apiResponse struct {
+		mpx.Response
+		Entries []struct {
+			Guid  string
+			Title string
+		}
+	}
+	if err := client.Get(mpx.Media, url.Values{
+		"byTitle": {query},
+		"fields":  {"guid,title"},
+		"count":   {"false"},
+	}, &apiResponse); err != nil {
+		return nil, err
+	}
+	results := make([]Result, len(apiResponse.Entries))
+	for i, e := range apiResponse.Entries {
+		results[i] = Result{ID: e.Guid, Title: e.Title}
+	}
+	return results, nil
+}
+
+func (p *mpxProvider) Resolve(id string, season, episode int) (*Stream, error) {
+	client, err := p.client()
+	if err != nil {
+		return nil, err
+	}
+	var apiResponse struct {
+		mpx.Response
+		Entries []struct {
+			Title   string
+			Content []struct {
+				StreamingURL string `json:"streamingUrl"`
+			}
+		}
+	}
+	if err := client.Get(mpx.Media, url.Values{
+		"byGuid": {id},
+		"fields": {"title,content.streamingUrl"},
+		"count":  {"false"},
+	}, &apiResponse); err != nil {
+		return nil, err
+	}
+	for _, entry := range apiResponse.Entries {
+		for _, content := range entry.Content {
+			if content.StreamingURL == "" {
+				continue
+			}
+			return &Stream{
+				Title:     entry.Title,
+				ProgramID: id,
+				HLSURL:    content.StreamingURL,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("mpx: no media found for guid %s", id)
+}
@@ -0,0 +1,106 @@
+// Package providers defines a common interface for resolving streamable
+// video content from different backend services (Stan, generic MPX feeds,
+// Brightcove-direct, etc) so the rest of the tool doesn't have to know
+// which service a show came from.
+package providers
+
+import (
+	"fmt"
+
+	"github.com/Tachines/HLS-Example/hls"
+)
+
+// Result is a single search hit for a show or movie.
+type Result struct {
+	ID    string
+	Title string
+}
+
+// Subtitle describes a single subtitle/caption track available on a Stream.
+type Subtitle struct {
+	Lang string
+	URL  string
+}
+
+// DRM carries whatever a provider needs in order to unlock playback of a
+// Stream. ContentID is provider-specific: for Stan it's the drmtoday
+// variantId/assetId query derived from the FairPlay skd URI.
+type DRM struct {
+	ContentID string
+}
+
+// Stream is everything the encode step needs, independent of which
+// provider produced it.
+type Stream struct {
+	Title     string
+	ProgramID string
+	HLSURL    string
+	DRM       DRM
+	Subtitles []Subtitle
+}
+
+// Provider resolves shows to streams for a single backend service.
+type Provider interface {
+	// Name identifies the provider for --provider and error messages.
+	Name() string
+	// Search looks up shows or movies matching query.
+	Search(query string) ([]Result, error)
+	// Resolve fetches the Stream for a given season/episode of id. season
+	// and episode of 0 mean "not applicable", e.g. for a movie.
+	Resolve(id string, season, episode int) (*Stream, error)
+}
+
+// EpisodeLister is implemented by providers that can enumerate the
+// episode numbers of a season ahead of resolving each one individually,
+// so callers can expand shorthand like "s1" into a batch of Resolve calls
+// without having to guess how many episodes a season has.
+type EpisodeLister interface {
+	ListEpisodes(id string, season int) ([]int, error)
+}
+
+// VariantSelector is implemented by providers whose Resolve step picks a
+// variant stream, audio track and subtitle track out of an HLS master
+// playlist, so callers can steer that choice with hls.Criteria instead of
+// always getting the provider's default pick.
+type VariantSelector interface {
+	SetVariantCriteria(hls.Criteria)
+}
+
+var (
+	registry = map[string]Provider{}
+	order    []string
+)
+
+// Register makes a Provider available by name. Providers call this from
+// an init() function.
+func Register(p Provider) {
+	name := p.Name()
+	if _, exists := registry[name]; !exists {
+		order = append(order, name)
+	}
+	registry[name] = p
+}
+
+// Get looks up a registered provider by name.
+func Get(name string) (Provider, error) {
+	p, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+	return p, nil
+}
+
+// Detect picks a provider for show when none was given explicitly. Each
+// registered provider is tried in registration order and the first one
+// whose Search returns a result wins, similar to how anirip and
+// dogetv-cli dispatch by probing sources in turn.
+func Detect(show string) (Provider, []Result, error) {
+	for _, name := range order {
+		p := registry[name]
+		results, err := p.Search(show)
+		if err == nil && len(results) > 0 {
+			return p, results, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("couldn't find %q on any provider", show)
+}
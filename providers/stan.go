@@ -0,0 +1,430 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	uuid "github.com/streamco/gouuid"
+	mpx "github.com/streamco/streamco-mpx-go"
+
+	"github.com/Tachines/HLS-Example/hls"
+	"github.com/Tachines/HLS-Example/session"
+)
+
+// stanSession holds the cached Stan bearer token and is shared by both the
+// plain HTTP calls below and the MPX client, so a login only has to happen
+// once and a 401 from either refreshes it for both.
+var stanSession = session.NewManager()
+
+var stanHTTPClient = &http.Client{Transport: stanSession.RoundTripper(nil)}
+
+func init() {
+	Register(&stanProvider{client: mpx.NewClientWithHTTPClient(stanHTTPClient)})
+}
+
+var shouldDumpTraffic = os.Getenv("DUMP_TRAFFIC") != ""
+
+// stanProvider resolves shows and episodes against Stan's search and
+// catalog APIs, then pulls the playable stream out of its Brightcove-backed
+// MPX media feed.
+type stanProvider struct {
+	client   mpx.Client
+	criteria hls.Criteria
+
+	seasonMu    sync.Mutex
+	seasonCache map[seasonCacheKey][]stanSeasonEntry
+}
+
+// seasonCacheKey identifies one show's season listing, so resolving a
+// batch of episodes from the same season (a whole-season or episode-range
+// request) only fetches that season's catalog listing once instead of
+// once per episode.
+type seasonCacheKey struct {
+	guid   string
+	season int
+}
+
+func (p *stanProvider) Name() string { return "stan" }
+
+// SetVariantCriteria satisfies providers.VariantSelector, letting callers
+// steer which rendition, audio track and subtitle track Resolve picks out
+// of the master playlist.
+func (p *stanProvider) SetVariantCriteria(c hls.Criteria) {
+	p.criteria = c
+}
+
+func stanFetch(url string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if shouldDumpTraffic {
+		dump, _ := httputil.DumpRequest(req, true)
+		println(string(dump))
+	}
+	res, err := stanHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if shouldDumpTraffic {
+		dump, _ := httputil.DumpResponse(res, true)
+		println(string(dump))
+	}
+	return res.Body, nil
+}
+
+func stanGetJSON(url string, out interface{}) error {
+	res, err := stanFetch(url)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	return json.NewDecoder(res).Decode(&out)
+}
+
+func (p *stanProvider) Search(query string) ([]Result, error) {
+	var apiResponse struct {
+		Entries []struct {
+			GUID  string
+			Title string
+		}
+	}
+	if err := stanGetJSON("https://v12.search.api.stan.com.au/search?q="+url.QueryEscape(query), &apiResponse); err != nil {
+		return nil, err
+	}
+	results := make([]Result, len(apiResponse.Entries))
+	for i, e := range apiResponse.Entries {
+		results[i] = Result{ID: e.GUID, Title: e.Title}
+	}
+	return results, nil
+}
+
+// stanEpisode is the Stan-specific episode handle needed to look up its
+// stream in the MPX media feed.
+type stanEpisode struct {
+	guid string
+	pid  string
+}
+
+// stanSeasonEntry is one episode entry of a season's catalog listing.
+type stanSeasonEntry struct {
+	URL           string
+	EpisodeNumber int
+}
+
+func (p *stanProvider) findSeasonURL(guid string, seasonNumber int) (string, error) {
+	var seriesRes struct {
+		GUID    string
+		Seasons []struct {
+			URL          string
+			SeasonNumber int
+		}
+	}
+	if err := stanGetJSON("https://v12.cat.api.stan.com.au/programs/"+guid+".json", &seriesRes); err != nil {
+		return "", err
+	}
+	for _, s := range seriesRes.Seasons {
+		if s.SeasonNumber == seasonNumber {
+			return s.URL, nil
+		}
+	}
+	return "", fmt.Errorf("no season %d, only these:%v", seasonNumber, seriesRes.Seasons)
+}
+
+// seasonEntries fetches the episode listing for (guid, seasonNumber),
+// caching the result so resolving many episodes of the same season (a
+// whole-season or episode-range batch) only hits Stan's catalog API once
+// instead of once per episode.
+func (p *stanProvider) seasonEntries(guid string, seasonNumber int) ([]stanSeasonEntry, error) {
+	key := seasonCacheKey{guid: guid, season: seasonNumber}
+
+	p.seasonMu.Lock()
+	entries, ok := p.seasonCache[key]
+	p.seasonMu.Unlock()
+	if ok {
+		return entries, nil
+	}
+
+	seasonURL, err := p.findSeasonURL(guid, seasonNumber)
+	if err != nil {
+		return nil, err
+	}
+	var seasonRes struct {
+		Entries []struct {
+			URL           string
+			EpisodeNumber int `json:"tvSeasonEpisodeNumber"`
+		}
+	}
+	if err := stanGetJSON(seasonURL, &seasonRes); err != nil {
+		return nil, err
+	}
+	entries = make([]stanSeasonEntry, len(seasonRes.Entries))
+	for i, e := range seasonRes.Entries {
+		entries[i] = stanSeasonEntry{URL: e.URL, EpisodeNumber: e.EpisodeNumber}
+	}
+
+	p.seasonMu.Lock()
+	if p.seasonCache == nil {
+		p.seasonCache = map[seasonCacheKey][]stanSeasonEntry{}
+	}
+	p.seasonCache[key] = entries
+	p.seasonMu.Unlock()
+	return entries, nil
+}
+
+// ListEpisodes satisfies providers.EpisodeLister so callers can expand
+// whole-season shorthand like "s1" without guessing the episode count.
+func (p *stanProvider) ListEpisodes(guid string, seasonNumber int) ([]int, error) {
+	entries, err := p.seasonEntries(guid, seasonNumber)
+	if err != nil {
+		return nil, err
+	}
+	numbers := make([]int, len(entries))
+	for i, e := range entries {
+		numbers[i] = e.EpisodeNumber
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func (p *stanProvider) getEpisode(guid string, seasonNumber, episodeNumber int) (stanEpisode, error) {
+	entries, err := p.seasonEntries(guid, seasonNumber)
+	if err != nil {
+		return stanEpisode{}, err
+	}
+	episodeURL := ""
+	for _, entry := range entries {
+		if entry.EpisodeNumber == episodeNumber {
+			episodeURL = entry.URL
+		}
+	}
+	if episodeURL == "" {
+		return stanEpisode{}, fmt.Errorf("no season %d episode %d", seasonNumber, episodeNumber)
+	}
+	var episodeRes struct {
+		GUID    string
+		Streams struct {
+			HD struct {
+				HLS struct {
+					Auto struct {
+						Pid string
+					}
+				}
+			}
+		}
+	}
+	if err := stanGetJSON(episodeURL, &episodeRes); err != nil {
+		return stanEpisode{}, err
+	}
+	return stanEpisode{guid: episodeRes.GUID, pid: episodeRes.Streams.HD.HLS.Auto.Pid}, nil
+}
+
+// getProgramStream looks up the stream pid directly off a program's
+// catalog entry, for standalone titles (movies) that don't have seasons.
+func (p *stanProvider) getProgramStream(guid string) (stanEpisode, error) {
+	var programRes struct {
+		GUID    string
+		Streams struct {
+			HD struct {
+				HLS struct {
+					Auto struct {
+						Pid string
+					}
+				}
+			}
+		}
+	}
+	if err := stanGetJSON("https://v12.cat.api.stan.com.au/programs/"+guid+".json", &programRes); err != nil {
+		return stanEpisode{}, err
+	}
+	if programRes.Streams.HD.HLS.Auto.Pid == "" {
+		return stanEpisode{}, fmt.Errorf("no stream found for program %s", guid)
+	}
+	return stanEpisode{guid: programRes.GUID, pid: programRes.Streams.HD.HLS.Auto.Pid}, nil
+}
+
+// StanAssetID translates a FairPlay skd URI of the form:
+// skd://brightcove/license/c8b3c68a17fb7946fa38f43db2251186/394234A_hd_6
+// to the hex string:
+// `2e17488975fc5d8f4b29ffc21a407a38`
+// this is the UUIDv5 form of `394234A_hd_6` in the URL namespace. It's
+// exported so the serve subcommand's local license proxy can derive the
+// same assetId the plist/json/m3u encoders embed.
+func StanAssetID(keyURI string) (string, error) {
+	// example brightcove uri
+	// skd://brightcove/license/c8b3c68a17fb7946fa38f43db2251186/394234A_hd_6
+	// we want the uuid v5 of 394234A_hd_6
+	// so we call path.Base and plug it into a UUIDv5
+	base := path.Base(keyURI)
+	v5uuid, err := uuid.NewV5(uuid.NamespaceURL, []byte(base))
+	if err != nil {
+		return "", fmt.Errorf("couldn't create a UUID from %s: %s", base, err)
+	}
+	return strings.Replace(v5uuid.String(), "-", "", -1), nil
+}
+
+func skdFromKeyURI(keyURI, variant string) (string, error) {
+	assetId, err := StanAssetID(keyURI)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`drmtoday?variantId=%s&assetId=%s`, variant, assetId), nil
+}
+
+// resolveStream fetches the master playlist at videoURL, picks a variant
+// matching p.criteria, and pulls its DRM key and subtitle tracks out of it.
+// overrideSKD used to hand-scan for the first .m3u8 line and the first
+// #EXT-X-KEY; this is now a thin adapter over the hls package's parser.
+func (p *stanProvider) resolveStream(videoURL, quality string) (DRM, []Subtitle, error) {
+	masterURL, err := url.Parse(videoURL)
+	if err != nil {
+		return DRM{}, nil, err
+	}
+	res, err := stanFetch(videoURL)
+	if err != nil {
+		return DRM{}, nil, err
+	}
+	defer res.Close()
+	master, err := hls.ParseMaster(masterURL, res)
+	if err != nil {
+		return DRM{}, nil, err
+	}
+
+	if len(master.Variants) == 0 {
+		return DRM{}, nil, fmt.Errorf("couldn't find any renditions in %s", videoURL)
+	}
+	variant, err := master.SelectVariant(p.criteria)
+	if err != nil {
+		if p.criteria != (hls.Criteria{}) {
+			log.Printf("stan: no variant in %s matches %+v, falling back to the first listed", videoURL, p.criteria)
+		}
+		// No variant matched the caller's criteria: fall back to the old
+		// behaviour of using whichever the playlist lists first. With no
+		// criteria SelectVariant picks the highest-bandwidth variant.
+		variant = &master.Variants[0]
+	}
+
+	renditionURL, err := url.Parse(variant.URI)
+	if err != nil {
+		return DRM{}, nil, err
+	}
+	res, err = stanFetch(variant.URI)
+	if err != nil {
+		return DRM{}, nil, err
+	}
+	defer res.Close()
+	rendition, err := hls.ParseMedia(renditionURL, res)
+	if err != nil {
+		return DRM{}, nil, err
+	}
+
+	var drm DRM
+	found := false
+	for _, key := range rendition.Keys {
+		if key.Method != "SAMPLE-AES" {
+			continue
+		}
+		skd, err := skdFromKeyURI(key.URI, quality)
+		if err != nil {
+			return DRM{}, nil, err
+		}
+		drm = DRM{ContentID: skd}
+		found = true
+		break
+	}
+	if !found {
+		return DRM{}, nil, fmt.Errorf("no EXT-X-KEY header found in %s", variant.URI)
+	}
+
+	var subtitles []Subtitle
+	for _, m := range master.Media {
+		if m.Type != "SUBTITLES" || m.GroupID != variant.Subtitles {
+			continue
+		}
+		if p.criteria.SubtitleLang != "" && m.Language != p.criteria.SubtitleLang {
+			continue
+		}
+		subtitles = append(subtitles, Subtitle{Lang: m.Language, URL: m.URI})
+	}
+	return drm, subtitles, nil
+}
+
+func (p *stanProvider) populateStream(ep stanEpisode) (*Stream, error) {
+	var apiResponse struct {
+		mpx.Response
+		Entries []struct {
+			Content []struct {
+				StreamingURL string `json:"streamingUrl"`
+				Quality      string `json:"sco$videoquality"`
+				Releases     []struct {
+					Pid string
+				}
+			}
+		}
+	}
+	if err := p.client.Get(mpx.Media, url.Values{
+		"byAvailabilityState": {"available"},
+		"byReleasePid":        {ep.pid},
+		"count":               {"false"},
+		"fields":              {"content,content.releases,content.sco$videoquality,content.streamingUrl"},
+		"schema":              {"1.8"},
+	}, &apiResponse); err != nil {
+		return nil, err
+	}
+	for _, entry := range apiResponse.Entries {
+		for _, content := range entry.Content {
+			for _, release := range content.Releases {
+				if release.Pid != ep.pid {
+					continue
+				}
+				drm, subtitles, err := p.resolveStream(content.StreamingURL, content.Quality)
+				if err != nil {
+					return nil, fmt.Errorf("couldn't get SKD from %s: %s", content.StreamingURL, err)
+				}
+				return &Stream{
+					ProgramID: ep.guid,
+					HLSURL:    content.StreamingURL,
+					DRM:       drm,
+					Subtitles: subtitles,
+				}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no media found for pid %s", ep.pid)
+}
+
+func (p *stanProvider) Resolve(id string, season, episode int) (*Stream, error) {
+	var (
+		ep  stanEpisode
+		err error
+	)
+	switch {
+	case season == 0 && episode == 0:
+		ep, err = p.getProgramStream(id)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get program: %s", err)
+		}
+	case season == 0 || episode == 0:
+		return nil, fmt.Errorf("stan: season and episode must both be given, or both be 0 for a movie")
+	default:
+		ep, err = p.getEpisode(id, season, episode)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get episode: %s", err)
+		}
+	}
+	stream, err := p.populateStream(ep)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get video deets for episode: %s", err)
+	}
+	return stream, nil
+}
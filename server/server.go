@@ -0,0 +1,307 @@
+// Package server runs a local HTTP reference player harness for a single
+// resolved stream: it rewrites the upstream HLS playlists so FairPlay
+// keys resolve to a local /license endpoint, forwards license requests to
+// Stan's DRMtoday through the caller's HTTP client, and proxies
+// segments through with Range support. This lets the SKD-translation
+// logic be exercised end-to-end by a real player without recompiling an
+// iOS app around it.
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Tachines/HLS-Example/hls"
+	"github.com/Tachines/HLS-Example/providers"
+)
+
+// drmTodayFairPlayURL is Stan's DRMtoday FairPlay license endpoint.
+const drmTodayFairPlayURL = "https://lic.drmtoday.com/license-server-fairplay/"
+
+// Server proxies a single resolved providers.Stream for local playback
+// testing.
+type Server struct {
+	stream *providers.Stream
+	client *http.Client
+	mux    *http.ServeMux
+
+	mu         sync.Mutex
+	knownHosts map[string]bool
+}
+
+// New builds a Server for stream. client is used for every upstream
+// request, so it should be the same authenticated client the provider
+// used to resolve stream. A session.Manager transport adds its bearer
+// token only to requests for stan.com.au hosts, so license requests to
+// DRMtoday are sent without it.
+func New(stream *providers.Stream, client *http.Client) *Server {
+	s := &Server{stream: stream, client: client, mux: http.NewServeMux(), knownHosts: map[string]bool{}}
+	s.mux.HandleFunc("/master.m3u8", s.handleMaster)
+	s.mux.HandleFunc("/rendition.m3u8", s.handleRendition)
+	s.mux.HandleFunc("/key", s.handleKey)
+	s.mux.HandleFunc("/license", s.handleLicense)
+	s.mux.HandleFunc("/segment", s.handleSegment)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// noteHost records rawURL's host as one that genuinely appeared in the
+// stream's own playlists, so a later /key or /segment request naming it
+// can be trusted.
+func (s *Server) noteHost(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	s.mu.Lock()
+	s.knownHosts[u.Host] = true
+	s.mu.Unlock()
+}
+
+// drmVariantID pulls the variantId out of s.stream.DRM.ContentID (a
+// "drmtoday?variantId=...&assetId=..." string built by the provider at
+// Resolve time), so /license always gets the same variantId the provider
+// already resolved the stream's DRM with, rather than re-deriving one
+// from the playlist's RESOLUTION attribute.
+func (s *Server) drmVariantID() (string, error) {
+	_, query, ok := strings.Cut(s.stream.DRM.ContentID, "?")
+	if !ok {
+		return "", fmt.Errorf("malformed DRM content id %q", s.stream.DRM.ContentID)
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("malformed DRM content id %q: %s", s.stream.DRM.ContentID, err)
+	}
+	variantId := values.Get("variantId")
+	if variantId == "" {
+		return "", fmt.Errorf("DRM content id %q has no variantId", s.stream.DRM.ContentID)
+	}
+	return variantId, nil
+}
+
+// hostAllowed reports whether rawURL points at a host that was seen in
+// the stream's own master or media playlists. This keeps /key and
+// /segment from being turned into an open proxy that forwards the
+// caller's session to an arbitrary third-party host.
+func (s *Server) hostAllowed(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.knownHosts[u.Host]
+}
+
+func (s *Server) get(upstream string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", upstream, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.client.Do(req)
+}
+
+func (s *Server) fetchMaster() (*url.URL, *hls.MasterPlaylist, error) {
+	masterURL, err := url.Parse(s.stream.HLSURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := s.get(s.stream.HLSURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	master, err := hls.ParseMaster(masterURL, res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.noteHost(s.stream.HLSURL)
+	for _, v := range master.Variants {
+		s.noteHost(v.URI)
+	}
+	return masterURL, master, nil
+}
+
+// handleMaster proxies the stream's master playlist, rewriting every
+// variant's URI to point back at this server's /rendition.m3u8.
+func (s *Server) handleMaster(w http.ResponseWriter, r *http.Request) {
+	_, master, err := s.fetchMaster()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	fmt.Fprintln(w, "#EXTM3U")
+	for i, v := range master.Variants {
+		fmt.Fprintf(w, "#EXT-X-STREAM-INF:BANDWIDTH=%d", v.Bandwidth)
+		if v.Resolution != "" {
+			fmt.Fprintf(w, ",RESOLUTION=%s", v.Resolution)
+		}
+		if v.Codecs != "" {
+			fmt.Fprintf(w, ",CODECS=%q", v.Codecs)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "/rendition.m3u8?variant=%d\n", i)
+	}
+}
+
+// handleRendition proxies the chosen variant's media playlist, pointing
+// its #EXT-X-KEY at /license and every segment at /segment.
+func (s *Server) handleRendition(w http.ResponseWriter, r *http.Request) {
+	idx, err := strconv.Atoi(r.URL.Query().Get("variant"))
+	if err != nil {
+		http.Error(w, "missing or invalid ?variant=", http.StatusBadRequest)
+		return
+	}
+	_, master, err := s.fetchMaster()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if idx < 0 || idx >= len(master.Variants) {
+		http.Error(w, "no such variant", http.StatusNotFound)
+		return
+	}
+	variant := master.Variants[idx]
+
+	renditionURL, err := url.Parse(variant.URI)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := s.get(variant.URI)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	rendition, err := hls.ParseMedia(renditionURL, res.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	for _, key := range rendition.Keys {
+		s.noteHost(key.URI)
+	}
+	for _, segment := range rendition.Segments {
+		s.noteHost(segment)
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	fmt.Fprintln(w, "#EXTM3U")
+	for _, key := range rendition.Keys {
+		if key.Method != "SAMPLE-AES" {
+			// Non-FairPlay keys (e.g. plain AES-128) are proxied as-is
+			// through /key rather than rewritten for /license.
+			fmt.Fprintf(w, "#EXT-X-KEY:METHOD=%s,URI=%q\n", key.Method, "/key?url="+url.QueryEscape(key.URI))
+			continue
+		}
+		assetId, err := providers.StanAssetID(key.URI)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		variantId, err := s.drmVariantID()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		licenseURI := fmt.Sprintf("/license?variantId=%s&assetId=%s", url.QueryEscape(variantId), assetId)
+		fmt.Fprintf(w, "#EXT-X-KEY:METHOD=%s,URI=%q,KEYFORMAT=%q\n", key.Method, licenseURI, key.KeyFormat)
+	}
+	for _, segment := range rendition.Segments {
+		fmt.Fprintf(w, "/segment?url=%s\n", url.QueryEscape(segment))
+	}
+}
+
+// handleKey proxies a non-FairPlay key file straight through.
+func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
+	upstream := r.URL.Query().Get("url")
+	if upstream == "" {
+		http.Error(w, "missing ?url=", http.StatusBadRequest)
+		return
+	}
+	if !s.hostAllowed(upstream) {
+		http.Error(w, "url is not a host from this stream's own playlists", http.StatusForbidden)
+		return
+	}
+	res, err := s.get(upstream)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	io.Copy(w, res.Body)
+}
+
+// handleLicense forwards a FairPlay SPC license request to Stan's
+// DRMtoday, carrying the variantId/assetId derived by StanAssetID. The
+// request goes out through s.client as is; see New for which hosts get
+// the session's bearer token.
+func (s *Server) handleLicense(w http.ResponseWriter, r *http.Request) {
+	assetId := r.URL.Query().Get("assetId")
+	if assetId == "" {
+		http.Error(w, "missing ?assetId=", http.StatusBadRequest)
+		return
+	}
+	upstream := fmt.Sprintf("%s?variantId=%s&assetId=%s", drmTodayFairPlayURL,
+		url.QueryEscape(r.URL.Query().Get("variantId")), url.QueryEscape(assetId))
+	req, err := http.NewRequest("POST", upstream, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := s.client.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(res.StatusCode)
+	io.Copy(w, res.Body)
+}
+
+// handleSegment proxies a media segment, forwarding the client's Range
+// header upstream and the upstream's response headers back.
+func (s *Server) handleSegment(w http.ResponseWriter, r *http.Request) {
+	upstream := r.URL.Query().Get("url")
+	if upstream == "" {
+		http.Error(w, "missing ?url=", http.StatusBadRequest)
+		return
+	}
+	if !s.hostAllowed(upstream) {
+		http.Error(w, "url is not a host from this stream's own playlists", http.StatusForbidden)
+		return
+	}
+	req, err := http.NewRequest("GET", upstream, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rng := r.Header.Get("Range"); rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	for _, h := range []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges"} {
+		if v := res.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(res.StatusCode)
+	io.Copy(w, res.Body)
+}
@@ -0,0 +1,274 @@
+// Package session manages Stan account authentication: logging in with a
+// username/password or a refresh token, caching the resulting bearer
+// token on disk, and transparently refreshing it when a request comes
+// back 401.
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config is the on-disk ~/.config/hls-example/config.json describing how
+// to authenticate with Stan.
+type Config struct {
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// Token is a cached Stan bearer token and its expiry.
+type Token struct {
+	AccessToken string    `json:"access_token"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+func (t *Token) expired() bool {
+	return t == nil || t.AccessToken == "" || time.Now().After(t.Expiry)
+}
+
+func configDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "hls-example"), nil
+}
+
+func configPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.json"), nil
+}
+
+func tokenPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "session.json"), nil
+}
+
+// LoadConfig reads ~/.config/hls-example/config.json, falling back to the
+// STAN_USERNAME, STAN_PASSWORD and STAN_REFRESH_TOKEN environment
+// variables for any field left unset on disk.
+func LoadConfig() (*Config, error) {
+	cfg := &Config{}
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parsing %s: %s", path, err)
+		}
+	}
+	if cfg.Username == "" {
+		cfg.Username = os.Getenv("STAN_USERNAME")
+	}
+	if cfg.Password == "" {
+		cfg.Password = os.Getenv("STAN_PASSWORD")
+	}
+	if cfg.RefreshToken == "" {
+		cfg.RefreshToken = os.Getenv("STAN_REFRESH_TOKEN")
+	}
+	return cfg, nil
+}
+
+func loadToken() (*Token, error) {
+	path, err := tokenPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Token
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", path, err)
+	}
+	return &t, nil
+}
+
+func saveToken(t *Token) error {
+	dir, err := configDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	path, err := tokenPath()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// Logout removes the cached session token, if any.
+func Logout() error {
+	path, err := tokenPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func login(cfg *Config) (*Token, error) {
+	var body url.Values
+	switch {
+	case cfg.RefreshToken != "":
+		body = url.Values{"grant_type": {"refresh_token"}, "refresh_token": {cfg.RefreshToken}}
+	case cfg.Username != "" && cfg.Password != "":
+		body = url.Values{"grant_type": {"password"}, "username": {cfg.Username}, "password": {cfg.Password}}
+	default:
+		return nil, fmt.Errorf("session: no credentials set, run `streams login` or set STAN_USERNAME/STAN_PASSWORD or STAN_REFRESH_TOKEN")
+	}
+	res, err := http.PostForm("https://login.stan.com.au/oauth/token", body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("session: login returned %s", res.Status)
+	}
+	var tokenRes struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokenRes); err != nil {
+		return nil, err
+	}
+	return &Token{
+		AccessToken: tokenRes.AccessToken,
+		Expiry:      time.Now().Add(time.Duration(tokenRes.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// Login authenticates against Stan with cfg and caches the resulting
+// token to disk with 0600 permissions.
+func Login(cfg *Config) (*Token, error) {
+	token, err := login(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveToken(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Manager hands out a valid bearer token, transparently logging in or
+// refreshing as needed. It is safe for concurrent use.
+type Manager struct {
+	mu    sync.Mutex
+	token *Token
+}
+
+// NewManager loads any token cached on disk; Token logs in lazily if none
+// is cached or it has expired.
+func NewManager() *Manager {
+	m := &Manager{}
+	if t, err := loadToken(); err == nil {
+		m.token = t
+	}
+	return m
+}
+
+// Token returns a valid bearer token, logging in or refreshing as needed.
+func (m *Manager) Token() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.token.expired() {
+		return m.token.AccessToken, nil
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	token, err := Login(cfg)
+	if err != nil {
+		return "", err
+	}
+	m.token = token
+	return token.AccessToken, nil
+}
+
+// Invalidate forces the next Token call to log in again, e.g. after an
+// upstream request comes back 401.
+func (m *Manager) Invalidate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.token = nil
+}
+
+// RoundTripper wraps base (http.DefaultTransport if nil) to inject the
+// current bearer token into every request bound for a Stan host, retrying
+// once with a fresh login if the upstream responds 401. Requests to any
+// other host (e.g. a CDN serving segments or keys) are passed through
+// unmodified, so the token never leaks to a third party.
+func (m *Manager) RoundTripper(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base, mgr: m}
+}
+
+type transport struct {
+	base http.RoundTripper
+	mgr  *Manager
+}
+
+// isStanHost reports whether host is stan.com.au or one of its
+// subdomains, the only hosts the bearer token should ever be sent to.
+func isStanHost(host string) bool {
+	host = strings.ToLower(host)
+	return host == "stan.com.au" || strings.HasSuffix(host, ".stan.com.au")
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isStanHost(req.URL.Hostname()) {
+		return t.base.RoundTrip(req)
+	}
+
+	token, err := t.mgr.Token()
+	if err != nil {
+		return nil, err
+	}
+	authed := req.Clone(req.Context())
+	authed.Header.Set("Authorization", "Bearer "+token)
+	res, err := t.base.RoundTrip(authed)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	res.Body.Close()
+	t.mgr.Invalidate()
+	token, err = t.mgr.Token()
+	if err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(retry)
+}
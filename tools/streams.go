@@ -1,283 +1,302 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
-	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 
-	uuid "github.com/streamco/gouuid"
-	mpx "github.com/streamco/streamco-mpx-go"
+	"github.com/Tachines/HLS-Example/encoders"
+	"github.com/Tachines/HLS-Example/hls"
+	"github.com/Tachines/HLS-Example/providers"
+	"github.com/Tachines/HLS-Example/server"
+	"github.com/Tachines/HLS-Example/session"
 )
 
-var shouldDump = os.Getenv("DUMP_TRAFFIC") != ""
+// maxConcurrentResolves bounds how many episodes are resolved in parallel
+// during a batch (whole-season or episode-range) request, so a big season
+// doesn't open dozens of simultaneous upstream connections at once.
+const maxConcurrentResolves = 4
 
-func fetch(url string) (io.ReadCloser, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	if shouldDump {
-		dump, _ := httputil.DumpRequest(req, true)
-		println(string(dump))
-	}
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	if shouldDump {
-		dump, _ := httputil.DumpResponse(res, true)
-		println(string(dump))
-	}
-	return res.Body, nil
+// episodeJob is a single title to resolve: season and episode are both 0
+// for a movie.
+type episodeJob struct {
+	label   string
+	season  int
+	episode int
 }
 
-func getJSON(url string, out interface{}) error {
-	res, err := fetch(url)
-	if err != nil {
-		return err
+var (
+	reRange  = regexp.MustCompile(`^s(\d+)e(\d+)-e(\d+)$`)
+	reSingle = regexp.MustCompile(`^s(\d+)e(\d+)$`)
+	reSeason = regexp.MustCompile(`^s(\d+)$`)
+)
+
+// parseJobs turns shorthand like "s1e1", "s1e1-e10", "s1" (whole season) or
+// a bare show name (movie) into the list of episodes to resolve.
+func parseJobs(p providers.Provider, id, show, shorthand string) ([]episodeJob, error) {
+	switch {
+	case reSingle.MatchString(shorthand):
+		m := reSingle.FindStringSubmatch(shorthand)
+		season, _ := strconv.Atoi(m[1])
+		ep, _ := strconv.Atoi(m[2])
+		return []episodeJob{{label: fmt.Sprintf("%s s%de%d", show, season, ep), season: season, episode: ep}}, nil
+
+	case reRange.MatchString(shorthand):
+		m := reRange.FindStringSubmatch(shorthand)
+		season, _ := strconv.Atoi(m[1])
+		start, _ := strconv.Atoi(m[2])
+		end, _ := strconv.Atoi(m[3])
+		if end < start {
+			return nil, fmt.Errorf("invalid episode range %s: e%d comes before e%d", shorthand, end, start)
+		}
+		jobs := make([]episodeJob, 0, end-start+1)
+		for ep := start; ep <= end; ep++ {
+			jobs = append(jobs, episodeJob{label: fmt.Sprintf("%s s%de%d", show, season, ep), season: season, episode: ep})
+		}
+		return jobs, nil
+
+	case reSeason.MatchString(shorthand):
+		m := reSeason.FindStringSubmatch(shorthand)
+		season, _ := strconv.Atoi(m[1])
+		lister, ok := p.(providers.EpisodeLister)
+		if !ok {
+			return nil, fmt.Errorf("provider %s can't enumerate whole seasons, ask for a specific episode or range", p.Name())
+		}
+		episodes, err := lister.ListEpisodes(id, season)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list season %d: %s", season, err)
+		}
+		jobs := make([]episodeJob, len(episodes))
+		for i, ep := range episodes {
+			jobs[i] = episodeJob{label: fmt.Sprintf("%s s%de%d", show, season, ep), season: season, episode: ep}
+		}
+		return jobs, nil
+
+	default:
+		// No sNeN shorthand at all: treat it as a movie.
+		return []episodeJob{{label: show, season: 0, episode: 0}}, nil
 	}
-	defer res.Close()
-	return json.NewDecoder(res).Decode(&out)
 }
 
-func getGUID(show string) (string, error) {
-	var apiResponse struct {
-		Entries []struct {
-			GUID string
+// resolveAll resolves every job against p concurrently, bounded by
+// maxConcurrentResolves, and collects per-job failures instead of
+// aborting the whole batch when one episode can't be found.
+func resolveAll(p providers.Provider, id string, jobs []episodeJob) []*providers.Stream {
+	streams := make([]*providers.Stream, len(jobs))
+	titles := make([]string, len(jobs))
+	sem := make(chan struct{}, maxConcurrentResolves)
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func(i int, job episodeJob) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			stream, err := p.Resolve(id, job.season, job.episode)
+			if err != nil {
+				log.Printf("couldn't resolve %s: %s", job.label, err)
+				return
+			}
+			streams[i] = stream
+			titles[i] = job.label
+		}(i, job)
+	}
+	wg.Wait()
+	resolved := make([]*providers.Stream, 0, len(streams))
+	for i, s := range streams {
+		if s == nil {
+			continue
 		}
+		s.Title = titles[i]
+		resolved = append(resolved, s)
 	}
-	if err := getJSON("https://v12.search.api.stan.com.au/search?q="+url.QueryEscape(show), &apiResponse); err != nil {
-		return "", err
-	}
-	if len(apiResponse.Entries) == 0 {
-		return "", fmt.Errorf("program not found")
-	}
-	return apiResponse.Entries[0].GUID, nil
+	return resolved
 }
 
-type episode struct {
-	id        string
-	pid       string
-	videoURL  string
-	contentID string
+// splitShowAndShorthand pulls the trailing sNeN/range/season token off
+// args as the episode shorthand; if the last word doesn't look like one,
+// the whole argument list is a movie title and there's no shorthand at
+// all.
+func splitShowAndShorthand(args []string) (show, shorthand string) {
+	last := args[len(args)-1]
+	if len(args) >= 2 && (reSingle.MatchString(last) || reRange.MatchString(last) || reSeason.MatchString(last)) {
+		return strings.Join(args[:len(args)-1], " "), last
+	}
+	return strings.Join(args, " "), ""
 }
 
-func getEpisode(guid string, seasonNumber, episodeNumber int) (episode, error) {
-	var seriesRes struct {
-		GUID    string
-		Seasons []struct {
-			URL          string
-			SeasonNumber int
-		}
+// resolveProvider picks providerName if given, otherwise auto-detects a
+// provider for show, and returns its search results.
+func resolveProvider(providerName, show string) (providers.Provider, []providers.Result, error) {
+	if providerName == "" {
+		return providers.Detect(show)
 	}
-	if err := getJSON("https://v12.cat.api.stan.com.au/programs/"+guid+".json", &seriesRes); err != nil {
-		return episode{}, err
+	p, err := providers.Get(providerName)
+	if err != nil {
+		return nil, nil, err
 	}
-	seasonURL := ""
-	for _, s := range seriesRes.Seasons {
-		if s.SeasonNumber == seasonNumber {
-			seasonURL = s.URL
-			break
-		}
+	results, err := p.Search(show)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't find show: %s", err)
 	}
-	if seasonURL == "" {
-		return episode{}, fmt.Errorf("no season %d, only these:%v", seasonNumber, seriesRes.Seasons)
+	if len(results) == 0 {
+		return nil, nil, fmt.Errorf("couldn't find show %q on provider %s", show, providerName)
 	}
-	var seasonRes struct {
-		Entries []struct {
-			URL           string
-			EpisodeNumber int `json:"tvSeasonEpisodeNumber"`
-		}
+	return p, results, nil
+}
+
+// runLogin handles `streams login`, authenticating against Stan and
+// caching the resulting bearer token to disk.
+func runLogin(args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	username := fs.String("username", "", "Stan account username/email")
+	password := fs.String("password", "", "Stan account password")
+	refreshToken := fs.String("refresh-token", "", "Stan refresh token, instead of username/password")
+	fs.Parse(args)
+
+	cfg, err := session.LoadConfig()
+	if err != nil {
+		log.Fatal(err)
 	}
-	if err := getJSON(seasonURL, &seasonRes); err != nil {
-		return episode{}, err
+	if *username != "" {
+		cfg.Username = *username
 	}
-	episodeURL := ""
-	for _, entry := range seasonRes.Entries {
-		if entry.EpisodeNumber == episodeNumber {
-			episodeURL = entry.URL
-		}
+	if *password != "" {
+		cfg.Password = *password
 	}
-	if episodeURL == "" {
-		return episode{}, fmt.Errorf("no season %d episode %d", seasonNumber, episodeNumber)
+	if *refreshToken != "" {
+		cfg.RefreshToken = *refreshToken
 	}
-	var episodeRes struct {
-		GUID    string
-		Streams struct {
-			HD struct {
-				HLS struct {
-					Auto struct {
-						Pid string
-					}
-				}
-			}
-		}
+	if _, err := session.Login(cfg); err != nil {
+		log.Fatalf("login failed: %s", err)
 	}
-	if err := getJSON(episodeURL, &episodeRes); err != nil {
-		return episode{}, err
+	fmt.Println("logged in")
+}
+
+// runLogout handles `streams logout`, discarding any cached session.
+func runLogout(args []string) {
+	if err := session.Logout(); err != nil {
+		log.Fatal(err)
 	}
-	return episode{id: episodeRes.GUID, pid: episodeRes.Streams.HD.HLS.Auto.Pid}, nil
+	fmt.Println("logged out")
 }
 
-// Fetches the m3u8, and translates the skd of the form:
-// skd://brightcove/license/c8b3c68a17fb7946fa38f43db2251186/394234A_hd_6
-// to the hex string:
-// `2e17488975fc5d8f4b29ffc21a407a38`
-// this is the UUIDv5 form of `394234A_hd_6` in the URL namespace
-func overrideSKD(videoURL, variant string) (string, error) {
-	m3u8URL, err := url.Parse(videoURL)
-	if err != nil {
-		return "", err
+// runServe handles `streams serve`, resolving a single title and starting
+// a local reference player harness for it.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	providerName := fs.String("provider", "", "provider to resolve the show with (default: auto-detect)")
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	maxBitrate := fs.Int("max-bitrate", 0, "reject variant renditions above this bitrate, in bits/sec (0 = no limit)")
+	resolution := fs.String("resolution", "", `exact rendition resolution to pick, e.g. "1920x1080" (default: highest bitrate)`)
+	audioLang := fs.String("audio-lang", "", "language of the alternate audio track to select, if the provider supports it")
+	subLang := fs.String("sub-lang", "", "language of the subtitle track to select, if the provider supports it")
+	fs.Parse(args)
+
+	rest := fs.Args()
+	if len(rest) < 1 {
+		log.Fatal("usage: streams serve [flags] younger s1e1")
 	}
-	res, err := fetch(videoURL)
+	show, shorthand := splitShowAndShorthand(rest)
+
+	p, results, err := resolveProvider(*providerName, show)
 	if err != nil {
-		return "", err
-	}
-	defer res.Close()
-	var renditionm3u8 string
-	scanner := bufio.NewScanner(res)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-		if strings.HasSuffix(line, ".m3u8") {
-			renditionPath, err := url.Parse(line)
-			if err != nil {
-				return "", fmt.Errorf("couldn't parse %s as URL: %s", line, err)
-			}
-			renditionm3u8 = m3u8URL.ResolveReference(renditionPath).String()
-			break
-		}
+		log.Fatal(err)
 	}
-	if renditionm3u8 == "" {
-		return "", fmt.Errorf("couldn't find any renditions in %s", videoURL)
+	if selector, ok := p.(providers.VariantSelector); ok {
+		selector.SetVariantCriteria(hls.Criteria{
+			MaxBandwidth: *maxBitrate,
+			Resolution:   *resolution,
+			AudioLang:    *audioLang,
+			SubtitleLang: *subLang,
+		})
 	}
-	res, err = fetch(renditionm3u8)
+
+	jobs, err := parseJobs(p, results[0].ID, show, shorthand)
 	if err != nil {
-		return "", err
+		log.Fatal(err)
 	}
-	defer res.Close()
-	scanner = bufio.NewScanner(res)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "#EXT-X-KEY:METHOD=SAMPLE-AES,URI=") {
-			var (
-				uri               string
-				keyFormat         string
-				keyFormatVersions string
-			)
-			if _, err := fmt.Sscanf(line, `#EXT-X-KEY:METHOD=SAMPLE-AES,URI=%q,KEYFORMAT=%q,KEYFORMATVERSIONS=%q`, &uri, &keyFormat, &keyFormatVersions); err != nil {
-				return "", fmt.Errorf("in %s, couldn't parse line %s", renditionm3u8, line)
-			}
-			// example brightcove uri
-			// skd://brightcove/license/c8b3c68a17fb7946fa38f43db2251186/394234A_hd_6
-			// we want the uuid v5 of 394234A_hd_6
-			// so we call path.Base and plug it into a UUIDv5
-			base := path.Base(uri)
-			v5uuid, err := uuid.NewV5(uuid.NamespaceURL, []byte(base))
-			if err != nil {
-				return "", fmt.Errorf("couldn't create a UUID from %s: %s", base, err)
-			}
-			assetId := strings.Replace(v5uuid.String(), "-", "", -1)
-			return fmt.Sprintf(`drmtoday?variantId=%s&assetId=%s`, variant, assetId), nil
-		}
+	if len(jobs) != 1 {
+		log.Fatalf("serve only plays a single title at a time, %q resolved to %d", shorthand, len(jobs))
+	}
+	stream, err := p.Resolve(results[0].ID, jobs[0].season, jobs[0].episode)
+	if err != nil {
+		log.Fatalf("couldn't resolve %s: %s", jobs[0].label, err)
 	}
-	return "", fmt.Errorf("no EXT-X-KEY header found in %s", renditionm3u8)
+
+	client := &http.Client{Transport: session.NewManager().RoundTripper(nil)}
+	srv := server.New(stream, client)
+	log.Printf("serving %s at http://%s/master.m3u8", jobs[0].label, *addr)
+	log.Fatal(http.ListenAndServe(*addr, srv))
 }
 
-func populateVideoDeets(client mpx.Client, ep *episode) error {
-	var apiResponse struct {
-		mpx.Response
-		Entries []struct {
-			Content []struct {
-				StreamingURL string `json:"streamingUrl"`
-				Quality      string `json:"sco$videoquality"`
-				Releases     []struct {
-					Pid string
-				}
-			}
-		}
-	}
-	if err := client.Get(mpx.Media, url.Values{
-		"byAvailabilityState": {"available"},
-		"byReleasePid":        {ep.pid},
-		"count":               {"false"},
-		"fields":              {"content,content.releases,content.sco$videoquality,content.streamingUrl"},
-		"schema":              {"1.8"},
-	}, &apiResponse); err != nil {
-		return err
-	}
-	for _, entry := range apiResponse.Entries {
-		for _, content := range entry.Content {
-			for _, release := range content.Releases {
-				if release.Pid == ep.pid {
-					ep.videoURL = content.StreamingURL
-					skd, err := overrideSKD(content.StreamingURL, content.Quality)
-					if err != nil {
-						return fmt.Errorf("couldn't get SKD from %s: %s", content.StreamingURL, err)
-					}
-					ep.contentID = skd
-					return nil
-				}
-			}
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "login":
+			runLogin(os.Args[2:])
+			return
+		case "logout":
+			runLogout(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
 		}
 	}
-	return fmt.Errorf("no media found for pid %s", ep.pid)
-}
 
-func encode(out io.Writer, title string, ep episode) error {
-	plist := `
-    <dict>
-        <key>AssetNameKey</key>
-        <string>%s</string>
-        <key>AAPLStreamPlaylistURL</key>
-        <string>%s</string>
-        <key>ContentID</key>
-        <string>%s</string>
-        <key>ProgramID</key>
-        <string>%s</string>
-    </dict>`
-	if _, err := fmt.Fprintf(out, plist+"\n", title, ep.videoURL, strings.Replace(ep.contentID, "&", "&amp;", -1), ep.id); err != nil {
-		return err
+	providerName := flag.String("provider", "", "provider to resolve the show with, e.g. stan, mpx, brightcove (default: auto-detect)")
+	format := flag.String("format", "plist", "output format: plist, json, m3u, strm")
+	outputDir := flag.String("output-dir", "", "directory to write files into, required for multi-file formats like strm")
+	maxBitrate := flag.Int("max-bitrate", 0, "reject variant renditions above this bitrate, in bits/sec (0 = no limit)")
+	resolution := flag.String("resolution", "", `exact rendition resolution to pick, e.g. "1920x1080" (default: highest bitrate)`)
+	audioLang := flag.String("audio-lang", "", "language of the alternate audio track to select, if the provider supports it")
+	subLang := flag.String("sub-lang", "", "language of the subtitle track to select, if the provider supports it")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		log.Fatal("usage: streams [--provider name] younger s1e1 (or: streams [--provider name] the matrix, for a movie)")
+	}
+	show, shorthand := splitShowAndShorthand(args)
+
+	p, results, err := resolveProvider(*providerName, show)
+	if err != nil {
+		log.Fatal(err)
 	}
-	return nil
-}
 
-func main() {
-	if len(os.Args) <= 2 {
-		log.Fatal("usage: streams younger s1e1")
+	if selector, ok := p.(providers.VariantSelector); ok {
+		selector.SetVariantCriteria(hls.Criteria{
+			MaxBandwidth: *maxBitrate,
+			Resolution:   *resolution,
+			AudioLang:    *audioLang,
+			SubtitleLang: *subLang,
+		})
 	}
-	show := strings.Join(os.Args[1:len(os.Args)-1], " ")
-	guid, err := getGUID(show)
+
+	jobs, err := parseJobs(p, results[0].ID, show, shorthand)
 	if err != nil {
-		log.Fatalf("couldn't find show: %s", err)
+		log.Fatal(err)
 	}
-	episodeShorthand := os.Args[len(os.Args)-1] // e.g. s1e1
-	var (
-		season  int
-		episode int
-	)
-	if _, err := fmt.Sscanf(episodeShorthand, "s%de%d", &season, &episode); err != nil {
-		log.Fatalf("couldn't parse %s as sNeN: %s", episodeShorthand, err)
+
+	streams := resolveAll(p, results[0].ID, jobs)
+	if len(streams) == 0 {
+		log.Fatalf("couldn't resolve any of %d requested title(s)", len(jobs))
 	}
-	ep, err := getEpisode(guid, season, episode)
+
+	enc, err := encoders.Get(*format)
 	if err != nil {
-		log.Fatalf("couldn't get episode: %s", err)
+		log.Fatal(err)
 	}
-	client := mpx.NewDefaultClient()
-	if err := populateVideoDeets(client, &ep); err != nil {
-		log.Fatalf("couldn't get video deets for episode: %s", err)
+	if enc.MultiFile() && *outputDir == "" {
+		log.Fatalf("--format %s writes one file per title, pass --output-dir", *format)
 	}
-	if err := encode(os.Stdout, show+" "+episodeShorthand, ep); err != nil {
+	if err := enc.Encode(streams, os.Stdout, *outputDir); err != nil {
 		log.Fatal(err)
 	}
 }